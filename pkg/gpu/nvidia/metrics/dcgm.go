@@ -0,0 +1,226 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// collectorNVML and collectorDCGM are the valid values for the --collector flag.
+const (
+	collectorNVML = "nvml"
+	collectorDCGM = "dcgm"
+)
+
+// dcgmProfilingFieldGroupName is the name DCGM registers the profiling field
+// group under. initDCGM creates the group on every call; it does not look up
+// or reuse a group of the same name left by an earlier call.
+const dcgmProfilingFieldGroupName = "cea-profiling"
+
+// dcgmProfilingFields are the DCGM fields polled by DCGMGather on top of the
+// plain NVML device status, used to fill in the metrics below.
+var dcgmProfilingFields = []dcgm.Short{
+	dcgm.DCGM_FI_PROF_PIPE_TENSOR_ACTIVE,
+	dcgm.DCGM_FI_PROF_SM_OCCUPANCY,
+	dcgm.DCGM_FI_PROF_DRAM_ACTIVE,
+	dcgm.DCGM_FI_DEV_GPU_TEMP,
+	dcgm.DCGM_FI_DEV_POWER_USAGE,
+}
+
+var (
+	// GPUTensorActiveRatio reports the fraction of time tensor cores were active.
+	GPUTensorActiveRatio = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gpu_tensor_active_ratio",
+			Help: "Fraction of time the tensor cores were active, as reported by DCGM",
+		},
+		[]string{"namespace", "pod", "container", "accelerator_id", "model"})
+
+	// GPUSMOccupancy reports the fraction of warps resident on the SMs.
+	GPUSMOccupancy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gpu_sm_occupancy",
+			Help: "Fraction of warps resident on the GPU SMs, as reported by DCGM",
+		},
+		[]string{"namespace", "pod", "container", "accelerator_id", "model"})
+
+	// GPUMemoryBandwidthUtilization reports the fraction of time the device memory interface was active.
+	GPUMemoryBandwidthUtilization = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gpu_memory_bandwidth_utilization",
+			Help: "Fraction of time the device memory interface was active, as reported by DCGM",
+		},
+		[]string{"namespace", "pod", "container", "accelerator_id", "model"})
+
+	// GPUTemperatureCelsius reports the current GPU die temperature.
+	GPUTemperatureCelsius = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gpu_temperature_celsius",
+			Help: "Current GPU die temperature in degrees Celsius",
+		},
+		[]string{"namespace", "pod", "container", "accelerator_id", "model"})
+
+	// GPUPowerUsageWatts reports the current power draw of the GPU.
+	GPUPowerUsageWatts = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gpu_power_usage_watts",
+			Help: "Current power draw of the GPU in watts",
+		},
+		[]string{"namespace", "pod", "container", "accelerator_id", "model"})
+)
+
+// DCGMGather implements gatherMetrics on top of the DCGM client instead of
+// raw NVML, so that profiling fields unavailable through NVML's DeviceStatus
+// (tensor/SM/memory-bandwidth activity) can be surfaced alongside the usual
+// duty-cycle and memory metrics.
+type DCGMGather struct {
+	fieldGroup dcgm.FieldHandle
+	groupID    dcgm.GroupHandle
+
+	// uuidToEntity caches the DCGM GPU entity ID for each NVML UUID, since
+	// DCGM addresses devices by entity ID rather than UUID.
+	uuidToEntity map[string]uint
+
+	// cleanup is the teardown func dcgm.Init returned for this specific
+	// Init call; shutdown must call it rather than the package-level
+	// dcgm.Terminate, which tears down global state other callers in the
+	// process may still depend on.
+	cleanup func()
+}
+
+// initDCGM starts DCGM in embedded mode, discovers the currently supported
+// devices, and subscribes to the profiling field group used by
+// gatherProfilingMetrics. It is safe to call once per process lifetime.
+func initDCGM() (*DCGMGather, error) {
+	cleanup, err := dcgm.Init(dcgm.Embedded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init dcgm: %v", err)
+	}
+
+	gpus, err := dcgm.GetSupportedDevices()
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to enumerate dcgm devices: %v", err)
+	}
+
+	groupID, err := dcgm.NewDefaultGroup("cea-gpus")
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to create dcgm group: %v", err)
+	}
+
+	fieldGroup, err := dcgm.FieldGroupCreate(dcgmProfilingFieldGroupName, dcgmProfilingFields)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to create dcgm field group: %v", err)
+	}
+
+	if err := dcgm.WatchFieldsWithGroupEx(fieldGroup, groupID, int64(time.Second/time.Microsecond), 0, 0); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to watch dcgm fields: %v", err)
+	}
+
+	uuidToEntity := make(map[string]uint, len(gpus))
+	for _, gpu := range gpus {
+		info, err := dcgm.GetDeviceInfo(gpu)
+		if err != nil {
+			glog.Warningf("Failed to get dcgm device info for gpu %d: %v", gpu, err)
+			continue
+		}
+		uuidToEntity[info.UUID] = gpu
+	}
+
+	return &DCGMGather{
+		fieldGroup:   fieldGroup,
+		groupID:      groupID,
+		uuidToEntity: uuidToEntity,
+		cleanup:      cleanup,
+	}, nil
+}
+
+func (d *DCGMGather) gatherDevice(deviceName string) (deviceWrapper, error) {
+	// Device identification (UUID, model, memory) still comes from NVML;
+	// DCGM is only consulted for the profiling fields below.
+	if isMigDevice(deviceName) {
+		return gatherMigDevice(deviceName)
+	}
+	dev, err := DeviceFromName(deviceName)
+	return &trueDeviceWrapper{dev}, err
+}
+
+func (d *DCGMGather) gatherStatus(dw deviceWrapper) (status *nvml.DeviceStatus, err error) {
+	return dw.giveStatus()
+}
+
+func (d *DCGMGather) gatherDutyCycle(uuid string, since time.Duration) (uint, error) {
+	if isMigDevice(uuid) {
+		return 0, errMigDutyCycleUnsupported
+	}
+	return AverageGPUUtilization(uuid, since)
+}
+
+// gatherProfilingMetrics reads the latest values of dcgmProfilingFields for
+// uuid and updates the Prometheus gauges above. Devices DCGM doesn't know
+// about (e.g. unsupported SKUs) are skipped rather than erroring the scrape.
+func (d *DCGMGather) gatherProfilingMetrics(container ContainerID, uuid, model string) error {
+	entity, ok := d.uuidToEntity[uuid]
+	if !ok {
+		glog.V(4).Infof("No dcgm entity known for device %s, skipping profiling metrics", uuid)
+		return nil
+	}
+
+	values, err := dcgm.GetLatestValuesForFields(entity, dcgmProfilingFields)
+	if err != nil {
+		return fmt.Errorf("failed to get dcgm field values for %s: %v", uuid, err)
+	}
+
+	labels := []string{container.namespace, container.pod, container.container, uuid, model}
+	for _, v := range values {
+		switch v.FieldID {
+		case dcgm.DCGM_FI_PROF_PIPE_TENSOR_ACTIVE:
+			GPUTensorActiveRatio.WithLabelValues(labels...).Set(v.Float64())
+		case dcgm.DCGM_FI_PROF_SM_OCCUPANCY:
+			GPUSMOccupancy.WithLabelValues(labels...).Set(v.Float64())
+		case dcgm.DCGM_FI_PROF_DRAM_ACTIVE:
+			GPUMemoryBandwidthUtilization.WithLabelValues(labels...).Set(v.Float64())
+		case dcgm.DCGM_FI_DEV_GPU_TEMP:
+			GPUTemperatureCelsius.WithLabelValues(labels...).Set(v.Float64())
+		case dcgm.DCGM_FI_DEV_POWER_USAGE:
+			GPUPowerUsageWatts.WithLabelValues(labels...).Set(v.Float64())
+		}
+	}
+	return nil
+}
+
+// shutdown releases the DCGM field watch and group created by initDCGM, then
+// runs the teardown closure dcgm.Init handed back for this Init call.
+func (d *DCGMGather) shutdown() {
+	if err := dcgm.FieldGroupDestroy(d.fieldGroup); err != nil {
+		glog.Warningf("Failed to destroy dcgm field group: %v", err)
+	}
+	if err := dcgm.DestroyGroup(d.groupID); err != nil {
+		glog.Warningf("Failed to destroy dcgm group: %v", err)
+	}
+	if d.cleanup != nil {
+		d.cleanup()
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/NVIDIA/go-dcgm/pkg/dcgm"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// healthFields are the DCGM fields polled by gatherHealthMetrics. None of
+// these have an NVML equivalent in the vendored bindings (no NVLink
+// counters, no public PCIe throughput or ECC error calls on *nvml.Device),
+// so health metrics are only available through the dcgm collector, the
+// same restriction gatherProfilingMetrics already has.
+var healthFields = []dcgm.Short{
+	dcgm.DCGM_FI_PROF_PCIE_TX_BYTES,
+	dcgm.DCGM_FI_PROF_PCIE_RX_BYTES,
+	dcgm.DCGM_FI_PROF_NVLINK_TX_BYTES,
+	dcgm.DCGM_FI_PROF_NVLINK_RX_BYTES,
+	dcgm.DCGM_FI_DEV_NVLINK_REPLAY_ERROR_COUNT_TOTAL,
+	dcgm.DCGM_FI_DEV_NVLINK_RECOVERY_ERROR_COUNT_TOTAL,
+	dcgm.DCGM_FI_DEV_NVLINK_CRC_ERROR_COUNT_TOTAL,
+	dcgm.DCGM_FI_DEV_ECC_SBE_VOL_TOTAL,
+	dcgm.DCGM_FI_DEV_ECC_DBE_VOL_TOTAL,
+}
+
+var (
+	// GPUNvLinkTxBytesTotal reports bytes transmitted over NVLink, aggregated
+	// across all of the GPU's links.
+	GPUNvLinkTxBytesTotal = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gpu_nvlink_tx_bytes_total",
+			Help: "Total bytes transmitted over NVLink, summed across all links on the GPU",
+		},
+		[]string{"namespace", "pod", "container", "accelerator_id", "model"})
+
+	// GPUNvLinkRxBytesTotal reports bytes received over NVLink, aggregated
+	// across all of the GPU's links.
+	GPUNvLinkRxBytesTotal = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gpu_nvlink_rx_bytes_total",
+			Help: "Total bytes received over NVLink, summed across all links on the GPU",
+		},
+		[]string{"namespace", "pod", "container", "accelerator_id", "model"})
+
+	// GPUNvLinkErrorsTotal reports NVLink error counters by kind, aggregated
+	// across all of the GPU's links.
+	GPUNvLinkErrorsTotal = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gpu_nvlink_errors_total",
+			Help: "Total NVLink errors by kind (replay, recovery, crc), summed across all links on the GPU",
+		},
+		[]string{"namespace", "pod", "container", "accelerator_id", "model", "kind"})
+
+	// GPUPcieTxThroughputBytes reports the PCIe transmit throughput.
+	GPUPcieTxThroughputBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gpu_pcie_tx_throughput_bytes",
+			Help: "PCIe transmit throughput in bytes/sec",
+		},
+		[]string{"namespace", "pod", "container", "accelerator_id", "model"})
+
+	// GPUPcieRxThroughputBytes reports the PCIe receive throughput.
+	GPUPcieRxThroughputBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gpu_pcie_rx_throughput_bytes",
+			Help: "PCIe receive throughput in bytes/sec",
+		},
+		[]string{"namespace", "pod", "container", "accelerator_id", "model"})
+
+	// GPUEccErrorsTotal reports ECC memory error counts by type, aggregated
+	// across all memory locations on the device.
+	GPUEccErrorsTotal = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gpu_ecc_errors_total",
+			Help: "Total ECC memory errors by type (corrected, uncorrected), summed across the device",
+		},
+		[]string{"namespace", "pod", "container", "accelerator_id", "model", "type"})
+)
+
+// gatherHealthMetrics reads the latest values of healthFields for uuid and
+// updates the gauges above. It's a DCGMGather method, not a free function
+// taking an *nvml.Device, because none of these counters are reachable
+// through NVML in the vendored bindings; devices DCGM doesn't know about
+// are skipped rather than erroring the scrape, same as
+// gatherProfilingMetrics.
+func (d *DCGMGather) gatherHealthMetrics(container ContainerID, uuid, model string) error {
+	entity, ok := d.uuidToEntity[uuid]
+	if !ok {
+		glog.V(4).Infof("No dcgm entity known for device %s, skipping health metrics", uuid)
+		return nil
+	}
+
+	values, err := dcgm.GetLatestValuesForFields(entity, healthFields)
+	if err != nil {
+		return fmt.Errorf("failed to get dcgm health field values for %s: %v", uuid, err)
+	}
+
+	labels := []string{container.namespace, container.pod, container.container, uuid, model}
+	for _, v := range values {
+		switch v.FieldID {
+		case dcgm.DCGM_FI_PROF_PCIE_TX_BYTES:
+			GPUPcieTxThroughputBytes.WithLabelValues(labels...).Set(v.Float64())
+		case dcgm.DCGM_FI_PROF_PCIE_RX_BYTES:
+			GPUPcieRxThroughputBytes.WithLabelValues(labels...).Set(v.Float64())
+		case dcgm.DCGM_FI_PROF_NVLINK_TX_BYTES:
+			GPUNvLinkTxBytesTotal.WithLabelValues(labels...).Set(v.Float64())
+		case dcgm.DCGM_FI_PROF_NVLINK_RX_BYTES:
+			GPUNvLinkRxBytesTotal.WithLabelValues(labels...).Set(v.Float64())
+		case dcgm.DCGM_FI_DEV_NVLINK_REPLAY_ERROR_COUNT_TOTAL:
+			GPUNvLinkErrorsTotal.WithLabelValues(append(labels, "replay")...).Set(v.Float64())
+		case dcgm.DCGM_FI_DEV_NVLINK_RECOVERY_ERROR_COUNT_TOTAL:
+			GPUNvLinkErrorsTotal.WithLabelValues(append(labels, "recovery")...).Set(v.Float64())
+		case dcgm.DCGM_FI_DEV_NVLINK_CRC_ERROR_COUNT_TOTAL:
+			GPUNvLinkErrorsTotal.WithLabelValues(append(labels, "crc")...).Set(v.Float64())
+		case dcgm.DCGM_FI_DEV_ECC_SBE_VOL_TOTAL:
+			GPUEccErrorsTotal.WithLabelValues(append(labels, "corrected")...).Set(v.Float64())
+		case dcgm.DCGM_FI_DEV_ECC_DBE_VOL_TOTAL:
+			GPUEccErrorsTotal.WithLabelValues(append(labels, "uncorrected")...).Set(v.Float64())
+		}
+	}
+	return nil
+}
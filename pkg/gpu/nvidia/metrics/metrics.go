@@ -54,6 +54,9 @@ var g gatherMetrics
 type TrueGather struct{}
 
 func (t *TrueGather) gatherDevice(deviceName string) (deviceWrapper, error) {
+	if isMigDevice(deviceName) {
+		return gatherMigDevice(deviceName)
+	}
 	d, err := DeviceFromName(deviceName)
 	return &trueDeviceWrapper{d}, err
 }
@@ -63,33 +66,43 @@ func (t *TrueGather) gatherStatus(d deviceWrapper) (status *nvml.DeviceStatus, e
 }
 
 func (t *TrueGather) gatherDutyCycle(uuid string, since time.Duration) (uint, error) {
+	if isMigDevice(uuid) {
+		return 0, errMigDutyCycleUnsupported
+	}
 	return AverageGPUUtilization(uuid, since)
 }
 
 var (
 	// DutyCycle reports the percent of time when the GPU was actively processing.
+	// gpu_instance_id/compute_instance_id are only populated for MIG slices.
+	// sharing_strategy is only populated when the device is shared by more
+	// than one container (see updateMetrics).
 	DutyCycle = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "duty_cycle",
 			Help: "Percent of time when the GPU was actively processing",
 		},
-		[]string{"namespace", "pod", "container", "make", "accelerator_id", "model"})
+		[]string{"namespace", "pod", "container", "make", "accelerator_id", "model", "gpu_instance_id", "compute_instance_id", "sharing_strategy"})
 
 	// MemoryTotal reports the total memory available on the GPU.
+	// gpu_instance_id/compute_instance_id are only populated for MIG slices.
 	MemoryTotal = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "memory_total",
 			Help: "Total memory available on the GPU in bytes",
 		},
-		[]string{"namespace", "pod", "container", "make", "accelerator_id", "model"})
+		[]string{"namespace", "pod", "container", "make", "accelerator_id", "model", "gpu_instance_id", "compute_instance_id"})
 
 	// MemoryUsed reports GPU memory allocated.
+	// gpu_instance_id/compute_instance_id are only populated for MIG slices.
+	// sharing_strategy is only populated when the device is shared by more
+	// than one container (see updateMetrics).
 	MemoryUsed = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "memory_used",
 			Help: "Allocated GPU memory in bytes",
 		},
-		[]string{"namespace", "pod", "container", "make", "accelerator_id", "model"})
+		[]string{"namespace", "pod", "container", "make", "accelerator_id", "model", "gpu_instance_id", "compute_instance_id", "sharing_strategy"})
 
 	// AcceleratorRequests reports the number of GPU devices requested by the container.
 	AcceleratorRequests = promauto.NewGaugeVec(
@@ -108,14 +121,33 @@ type MetricServer struct {
 	port                 int
 	metricsEndpointPath  string
 	lastMetricsResetTime time.Time
+
+	// collector selects the metrics backend ("nvml" or "dcgm"). It falls
+	// back to "nvml" if dcgm initialization fails, see Start.
+	collector string
+	dcgm      *DCGMGather
+
+	processMetrics *ProcessMetricsCollector
+
+	// sharingStrategy labels metrics for devices shared by more than one
+	// container (e.g. "time-slicing", "mps", "mig"). Empty means devices
+	// aren't expected to be shared.
+	sharingStrategy string
+
+	// collectionParallelism bounds how many devices are gathered
+	// concurrently each scrape. Defaults to the discovered GPU count if <1.
+	collectionParallelism int
 }
 
-func NewMetricServer(collectionInterval, port int, metricsEndpointPath string) *MetricServer {
+func NewMetricServer(collectionInterval, port int, metricsEndpointPath, collector, sharingStrategy string, collectionParallelism int) *MetricServer {
 	return &MetricServer{
-		collectionInterval:   collectionInterval,
-		port:                 port,
-		metricsEndpointPath:  metricsEndpointPath,
-		lastMetricsResetTime: time.Now(),
+		collectionInterval:    collectionInterval,
+		port:                  port,
+		metricsEndpointPath:   metricsEndpointPath,
+		lastMetricsResetTime:  time.Now(),
+		collector:             collector,
+		sharingStrategy:       sharingStrategy,
+		collectionParallelism: collectionParallelism,
 	}
 }
 
@@ -134,6 +166,25 @@ func (m *MetricServer) Start() error {
 		return fmt.Errorf("failed to discover GPU devices: %v", err)
 	}
 
+	if m.collectionParallelism < 1 {
+		if count, err := nvml.GetDeviceCount(); err != nil {
+			glog.Warningf("Failed to get gpu count for default collection parallelism, using 1: %v", err)
+			m.collectionParallelism = 1
+		} else {
+			m.collectionParallelism = int(count)
+		}
+	}
+
+	if m.collector == collectorDCGM {
+		dg, err := initDCGM()
+		if err != nil {
+			glog.Warningf("Failed to initialize dcgm collector, falling back to nvml: %v", err)
+			m.collector = collectorNVML
+		} else {
+			m.dcgm = dg
+		}
+	}
+
 	go func() {
 		http.Handle(m.metricsEndpointPath, promhttp.Handler())
 		err := http.ListenAndServe(fmt.Sprintf(":%d", m.port), nil)
@@ -147,51 +198,151 @@ func (m *MetricServer) Start() error {
 }
 
 func (m *MetricServer) collectMetrics() {
-	g = &TrueGather{}
+	if m.collector == collectorDCGM && m.dcgm != nil {
+		g = m.dcgm
+	} else {
+		g = &TrueGather{}
+	}
+	m.processMetrics = &ProcessMetricsCollector{}
 	t := time.NewTicker(time.Millisecond * time.Duration(m.collectionInterval))
 	defer t.Stop()
 
 	for {
 		select {
 		case <-t.C:
+			start := time.Now()
 			devices, err := GetDevicesForAllContainers()
 			if err != nil {
 				glog.Errorf("Failed to get devices for containers: %v", err)
 				continue
 			}
-			m.updateMetrics(devices)
+			// Reset before gathering, not inside updateMetrics, since
+			// collectDeviceResults sets some gauges (e.g. MigMode) as a
+			// side effect of gathering and a later reset would wipe them
+			// out again before the next scrape.
+			m.resetMetricsIfNeeded()
+			results := m.collectDeviceResults(devices)
+			m.updateMetrics(devices, results)
+			if m.collector == collectorDCGM && m.dcgm != nil {
+				m.updateDCGMProfilingMetrics(devices)
+			}
+			m.updateProcessMetrics(devices)
+			GPUCollectionDurationSeconds.Observe(time.Since(start).Seconds())
 		}
 	}
 }
 
-func (m *MetricServer) updateMetrics(containerDevices map[ContainerID][]string) {
-	m.resetMetricsIfNeeded()
-
+// updateProcessMetrics walks every container's devices and attributes
+// per-process GPU activity on each to the container that owns it. It's a
+// second, independent pass over the NVML process-accounting calls rather
+// than being woven into updateMetrics, so a slow or failing device here
+// never holds up the whole-device duty-cycle/memory metrics.
+func (m *MetricServer) updateProcessMetrics(containerDevices map[ContainerID][]string) {
 	for container, devices := range containerDevices {
-		AcceleratorRequests.WithLabelValues(container.namespace, container.pod, container.container, gpuResourceName).Set(float64(len(devices)))
+		for _, device := range devices {
+			if err := m.processMetrics.collectProcessMetrics(container, device); err != nil {
+				glog.Errorf("Failed to collect process metrics for %s: %v", device, err)
+				GPUCollectionErrorsTotal.WithLabelValues(device, "process_metrics").Inc()
+			}
+		}
+	}
+}
 
+// updateDCGMProfilingMetrics polls the DCGM profiling fields (tensor/SM/
+// memory-bandwidth activity, temperature, power) for every container's
+// devices. It runs as its own pass rather than from inside updateMetrics
+// because it goes through a different client (dcgm, not the gatherMetrics
+// interface) with its own field-watch lifecycle to manage.
+func (m *MetricServer) updateDCGMProfilingMetrics(containerDevices map[ContainerID][]string) {
+	for container, devices := range containerDevices {
 		for _, device := range devices {
-			dw, err := g.gatherDevice(device)
+			dw, err := m.dcgm.gatherDevice(device)
 			if err != nil {
 				glog.Errorf("Failed to get device for %s: %v", device, err)
+				GPUCollectionErrorsTotal.WithLabelValues(device, "dcgm_gather_device").Inc()
 				continue
 			}
-
-			status, err := g.gatherStatus(dw)
-			if err != nil {
-				glog.Errorf("Failed to get device status for %s: %v", device, err)
-			}
 			d := dw.giveDevice()
-			mem := status.Memory
-			dutyCycle, err := g.gatherDutyCycle(d.UUID, time.Second*10)
-			if err != nil {
-				glog.Infof("Error calculating duty cycle for device: %s: %v. Skipping this device", device, err)
-				continue
+			if err := m.dcgm.gatherProfilingMetrics(container, d.UUID, *d.Model); err != nil {
+				glog.Errorf("Failed to gather dcgm profiling metrics for %s: %v", device, err)
+				GPUCollectionErrorsTotal.WithLabelValues(device, "dcgm_profiling").Inc()
 			}
+			if err := m.dcgm.gatherHealthMetrics(container, d.UUID, *d.Model); err != nil {
+				glog.Errorf("Failed to gather dcgm health metrics for %s: %v", device, err)
+				GPUCollectionErrorsTotal.WithLabelValues(device, "dcgm_health").Inc()
+			}
+		}
+	}
+}
+
+// updateMetrics is the sole aggregator of the per-scrape results gathered
+// concurrently by collectDeviceResults: it's the only place that touches
+// the Prometheus vectors below, so their internal maps never see
+// concurrent writers.
+func (m *MetricServer) updateMetrics(containerDevices map[ContainerID][]string, results []gatherResult) {
+	// Invert the map up front so a shared device (N>1 containers) can be
+	// detected before any metric for it is emitted; Prometheus only keeps
+	// the last Set() per label combination each scrape, so naively looping
+	// containers would have the last container silently win.
+	deviceContainers := invertContainerDevices(containerDevices)
+	reportedShared := make(map[string]bool)
 
-			DutyCycle.WithLabelValues(container.namespace, container.pod, container.container, "nvidia", d.UUID, *d.Model).Set(float64(dutyCycle))
-			MemoryTotal.WithLabelValues(container.namespace, container.pod, container.container, "nvidia", d.UUID, *d.Model).Set(float64(*d.Memory) * 1024 * 1024)       // memory reported in bytes
-			MemoryUsed.WithLabelValues(container.namespace, container.pod, container.container, "nvidia", d.UUID, *d.Model).Set(float64(*mem.Global.Used) * 1024 * 1024) // memory reported in bytes
+	for container, devices := range containerDevices {
+		var requested float64
+		for _, device := range devices {
+			requested += shareOf(deviceContainers[device])
+		}
+		AcceleratorRequests.WithLabelValues(container.namespace, container.pod, container.container, gpuResourceName).Set(requested)
+	}
+
+	for _, result := range results {
+		container, device := result.job.container, result.job.device
+
+		if result.err != nil {
+			glog.Errorf("Failed to get device for %s: %v", device, result.err)
+			continue
+		}
+		if result.statusErr != nil {
+			glog.Errorf("Failed to get device status for %s: %v", device, result.statusErr)
+		}
+		if result.status == nil {
+			continue
+		}
+
+		dw := result.dw
+		d := dw.giveDevice()
+		mem := result.status.Memory
+		gpuInstanceID, computeInstanceID := migLabelsFor(dw)
+
+		sharers := deviceContainers[device]
+		shared := len(sharers) > 1
+		sharingLabel := ""
+		if shared {
+			sharingLabel = m.sharingStrategy
+			GPUSharingReplicas.WithLabelValues(d.UUID, m.sharingStrategy).Set(float64(len(sharers)))
+		}
+
+		switch {
+		case result.dutyCycleErr == errMigDutyCycleUnsupported:
+			glog.V(4).Infof("Duty cycle not available for MIG device %s, skipping duty_cycle metric", device)
+		case result.dutyCycleErr != nil:
+			glog.Infof("Error calculating duty cycle for device: %s: %v. Skipping this device", device, result.dutyCycleErr)
+			continue
+		default:
+			DutyCycle.WithLabelValues(container.namespace, container.pod, container.container, "nvidia", d.UUID, *d.Model, gpuInstanceID, computeInstanceID, sharingLabel).Set(float64(result.dutyCycle))
+			if shared && !reportedShared[device+"/duty_cycle"] {
+				DutyCycle.WithLabelValues(sharedContainerLabel, sharedContainerLabel, sharedContainerLabel, "nvidia", d.UUID, *d.Model, gpuInstanceID, computeInstanceID, m.sharingStrategy).Set(float64(result.dutyCycle))
+				reportedShared[device+"/duty_cycle"] = true
+			}
+		}
+
+		MemoryTotal.WithLabelValues(container.namespace, container.pod, container.container, "nvidia", d.UUID, *d.Model, gpuInstanceID, computeInstanceID).Set(float64(*d.Memory) * 1024 * 1024) // memory reported in bytes
+
+		memUsed := float64(*mem.Global.Used) * 1024 * 1024 // memory reported in bytes
+		MemoryUsed.WithLabelValues(container.namespace, container.pod, container.container, "nvidia", d.UUID, *d.Model, gpuInstanceID, computeInstanceID, sharingLabel).Set(memUsed)
+		if shared && !reportedShared[device+"/memory_used"] {
+			MemoryUsed.WithLabelValues(sharedContainerLabel, sharedContainerLabel, sharedContainerLabel, "nvidia", d.UUID, *d.Model, gpuInstanceID, computeInstanceID, m.sharingStrategy).Set(memUsed)
+			reportedShared[device+"/memory_used"] = true
 		}
 	}
 }
@@ -202,6 +353,22 @@ func (m *MetricServer) resetMetricsIfNeeded() {
 		DutyCycle.Reset()
 		MemoryTotal.Reset()
 		MemoryUsed.Reset()
+		MigMode.Reset()
+		GPUSharingReplicas.Reset()
+		GPUProcessMemoryBytes.Reset()
+		if m.collector == collectorDCGM {
+			GPUTensorActiveRatio.Reset()
+			GPUSMOccupancy.Reset()
+			GPUMemoryBandwidthUtilization.Reset()
+			GPUTemperatureCelsius.Reset()
+			GPUPowerUsageWatts.Reset()
+			GPUNvLinkRxBytesTotal.Reset()
+			GPUNvLinkTxBytesTotal.Reset()
+			GPUNvLinkErrorsTotal.Reset()
+			GPUPcieRxThroughputBytes.Reset()
+			GPUPcieTxThroughputBytes.Reset()
+			GPUEccErrorsTotal.Reset()
+		}
 
 		m.lastMetricsResetTime = time.Now()
 	}
@@ -209,4 +376,7 @@ func (m *MetricServer) resetMetricsIfNeeded() {
 
 // Stop performs cleanup operations and stops the metric server.
 func (m *MetricServer) Stop() {
+	if m.dcgm != nil {
+		m.dcgm.shutdown()
+	}
 }
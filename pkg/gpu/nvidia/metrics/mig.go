@@ -0,0 +1,180 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// migUUIDPrefix marks a device UUID as addressing a MIG GPU instance rather
+// than a whole physical GPU. MIG UUIDs take the form
+// "MIG-GPU-<parent-uuid>/<gpu-instance-id>/<compute-instance-id>".
+const migUUIDPrefix = "MIG-"
+
+// errMigDutyCycleUnsupported is returned by gatherDutyCycle for MIG devices,
+// since NVML doesn't report per-slice utilization; callers should skip the
+// DutyCycle metric for this device rather than falling back to the parent
+// GPU's value.
+var errMigDutyCycleUnsupported = errors.New("duty cycle is not available per MIG slice")
+
+var (
+	// MigMode reports whether MIG mode is enabled (1) or disabled (0) on a physical GPU.
+	MigMode = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mig_mode",
+			Help: "Whether MIG mode is enabled (1) or disabled (0) on the physical GPU",
+		},
+		[]string{"accelerator_id", "model"})
+)
+
+// migDeviceWrapper wraps a single MIG GPU instance the same way
+// trueDeviceWrapper wraps a whole physical GPU, so gatherDevice/gatherStatus
+// can treat both uniformly through the deviceWrapper interface.
+type migDeviceWrapper struct {
+	device            nvml.Device
+	gpuInstanceID     string
+	computeInstanceID string
+}
+
+func (d *migDeviceWrapper) giveDevice() *nvml.Device {
+	return &d.device
+}
+
+func (d *migDeviceWrapper) giveStatus() (status *nvml.DeviceStatus, err error) {
+	return d.device.Status()
+}
+
+// isMigDevice reports whether deviceName addresses a MIG GPU instance rather
+// than a whole physical GPU.
+func isMigDevice(deviceName string) bool {
+	return strings.HasPrefix(deviceName, migUUIDPrefix)
+}
+
+// parseMigUUID splits a MIG UUID into its parent GPU UUID and the GPU
+// instance/compute instance indices it was created from.
+func parseMigUUID(deviceName string) (parentUUID string, gpuInstanceID, computeInstanceID int, err error) {
+	parts := strings.Split(strings.TrimPrefix(deviceName, migUUIDPrefix), "/")
+	if len(parts) != 3 {
+		return "", 0, 0, fmt.Errorf("unrecognized mig uuid format: %s", deviceName)
+	}
+	gpuInstanceID, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid gpu instance id in %s: %v", deviceName, err)
+	}
+	computeInstanceID, err = strconv.Atoi(parts[2])
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid compute instance id in %s: %v", deviceName, err)
+	}
+	// parts[0] is already the bare parent GPU UUID (e.g. "GPU-xxxx"); it
+	// never carries the "MIG-" prefix, so it must not be re-added here.
+	return parts[0], gpuInstanceID, computeInstanceID, nil
+}
+
+// gatherMigDevice resolves a MIG slice UUID to its parent GPU and fetches a
+// handle scoped to that GPU instance/compute instance, so memory metrics
+// reflect the slice's own limits rather than the whole physical GPU's.
+func gatherMigDevice(deviceName string) (deviceWrapper, error) {
+	parentUUID, gpuInstanceID, computeInstanceID, err := parseMigUUID(deviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := DeviceFromName(parentUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find parent gpu for mig device %s: %v", deviceName, err)
+	}
+
+	// The handle is addressed by its single GPU instance index; NVML has no
+	// separate compute-instance argument here; computeInstanceID is kept
+	// only as a label, parsed straight out of the UUID.
+	migHandle, err := parent.GetMigDeviceHandleByIndex(gpuInstanceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mig device handle for %s: %v", deviceName, err)
+	}
+
+	if migEnabled, err := parent.IsMigEnabled(); err != nil {
+		glog.V(4).Infof("Failed to query mig mode for %s: %v", parentUUID, err)
+	} else {
+		MigMode.WithLabelValues(parentUUID, *parent.Model).Set(boolToFloat(migEnabled))
+	}
+
+	return &migDeviceWrapper{
+		device:            *migHandle,
+		gpuInstanceID:     strconv.Itoa(gpuInstanceID),
+		computeInstanceID: strconv.Itoa(computeInstanceID),
+	}, nil
+}
+
+// maxMigInstancesPerGPU bounds how many GPU instance indices DiscoverMigUUIDs
+// probes per physical device; current NVIDIA hardware supports at most 7
+// (e.g. an A100 split into 7x 1g.5gb slices).
+const maxMigInstancesPerGPU = 7
+
+// DiscoverMigUUIDs enumerates the MIG GPU instances currently carved out of
+// parent and returns their slice UUIDs exactly as NVML reports them, in the
+// "MIG-<parent-uuid>/<gpu-instance>/<compute-instance>" form parseMigUUID
+// and gatherMigDevice expect. parentUUID is the bare physical GPU UUID
+// (e.g. "GPU-xxxx"), the same form parseMigUUID returns. DiscoverGPUDevices
+// is responsible for calling this once per physical device it discovers and
+// merging the results into the device list it hands to
+// GetDevicesForAllContainers, so MIG slices become visible to updateMetrics
+// in the first place; that wiring lives outside this package's current
+// source tree and isn't covered by this change.
+func DiscoverMigUUIDs(parentUUID string, parent nvml.Device) ([]string, error) {
+	migEnabled, err := parent.IsMigEnabled()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query mig mode for %s: %v", parentUUID, err)
+	}
+	if !migEnabled {
+		return nil, nil
+	}
+
+	var uuids []string
+	for i := 0; i < maxMigInstancesPerGPU; i++ {
+		mig, err := parent.GetMigDeviceHandleByIndex(i)
+		if err != nil {
+			// Instances are packed from index 0, so the first miss means
+			// there's nothing further to discover on this device.
+			break
+		}
+		uuids = append(uuids, mig.UUID)
+	}
+	return uuids, nil
+}
+
+// migLabelsFor returns the gpu_instance_id/compute_instance_id label values
+// for dw, or a pair of empty strings for a whole-GPU device.
+func migLabelsFor(dw deviceWrapper) (gpuInstanceID, computeInstanceID string) {
+	mig, ok := dw.(*migDeviceWrapper)
+	if !ok {
+		return "", ""
+	}
+	return mig.gpuInstanceID, mig.computeInstanceID
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import "testing"
+
+func TestParseMigUUID(t *testing.T) {
+	tests := []struct {
+		name                  string
+		deviceName            string
+		wantParentUUID        string
+		wantGpuInstanceID     int
+		wantComputeInstanceID int
+		wantErr               bool
+	}{
+		{
+			name:                  "valid mig uuid",
+			deviceName:            "MIG-GPU-1a2b3c4d/2/3",
+			wantParentUUID:        "GPU-1a2b3c4d",
+			wantGpuInstanceID:     2,
+			wantComputeInstanceID: 3,
+		},
+		{
+			name:       "missing segments",
+			deviceName: "MIG-GPU-1a2b3c4d/2",
+			wantErr:    true,
+		},
+		{
+			name:       "non-numeric gpu instance id",
+			deviceName: "MIG-GPU-1a2b3c4d/x/3",
+			wantErr:    true,
+		},
+		{
+			name:       "non-numeric compute instance id",
+			deviceName: "MIG-GPU-1a2b3c4d/2/x",
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parentUUID, gpuInstanceID, computeInstanceID, err := parseMigUUID(tt.deviceName)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseMigUUID(%q) = nil error, want error", tt.deviceName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMigUUID(%q) returned unexpected error: %v", tt.deviceName, err)
+			}
+			if parentUUID != tt.wantParentUUID {
+				t.Errorf("parseMigUUID(%q) parentUUID = %q, want %q", tt.deviceName, parentUUID, tt.wantParentUUID)
+			}
+			if gpuInstanceID != tt.wantGpuInstanceID {
+				t.Errorf("parseMigUUID(%q) gpuInstanceID = %d, want %d", tt.deviceName, gpuInstanceID, tt.wantGpuInstanceID)
+			}
+			if computeInstanceID != tt.wantComputeInstanceID {
+				t.Errorf("parseMigUUID(%q) computeInstanceID = %d, want %d", tt.deviceName, computeInstanceID, tt.wantComputeInstanceID)
+			}
+		})
+	}
+}
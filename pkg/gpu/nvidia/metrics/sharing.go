@@ -0,0 +1,97 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Sharing strategies recognized by the sharing_strategy metric label.
+const (
+	SharingStrategyTimeSlicing = "time-slicing"
+	SharingStrategyMPS         = "mps"
+	SharingStrategyMIG         = "mig"
+)
+
+// sharedContainerLabel is used in place of namespace/pod/container for the
+// single aggregate sample a shared device gets, alongside the per-container
+// copies.
+const sharedContainerLabel = "_shared_"
+
+// sharingConfigMapKey is the key LoadSharingStrategy looks for in the
+// per-node ConfigMap, e.g. a projected ConfigMap volume with a
+// "sharing-strategy" file holding "time-slicing".
+const sharingConfigMapKey = "sharing-strategy"
+
+var (
+	// GPUSharingReplicas reports how many containers are currently sharing a single device.
+	GPUSharingReplicas = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gpu_sharing_replicas",
+			Help: "Number of containers currently sharing a single GPU device",
+		},
+		[]string{"accelerator_id", "sharing_strategy"})
+)
+
+// invertContainerDevices turns the container->devices map produced by
+// GetDevicesForAllContainers into device->containers, so updateMetrics can
+// tell up front which devices are shared by more than one container.
+func invertContainerDevices(containerDevices map[ContainerID][]string) map[string][]ContainerID {
+	deviceContainers := make(map[string][]ContainerID)
+	for container, devices := range containerDevices {
+		for _, device := range devices {
+			deviceContainers[device] = append(deviceContainers[device], container)
+		}
+	}
+	return deviceContainers
+}
+
+// shareOf returns the fractional share of a device attributed to one of
+// sharers containers, e.g. 1/N for N containers time-slicing the same UUID.
+func shareOf(sharers []ContainerID) float64 {
+	if len(sharers) == 0 {
+		return 0
+	}
+	return 1 / float64(len(sharers))
+}
+
+// LoadSharingStrategy reads the sharing strategy for this node from a
+// projected ConfigMap volume at configMapPath (a directory containing a
+// "sharing-strategy" file), falling back to defaultStrategy if the file
+// isn't present. This lets a DaemonSet roll out a shared-GPU strategy via
+// ConfigMap without restarting with a new flag value.
+func LoadSharingStrategy(configMapPath, defaultStrategy string) (string, error) {
+	f, err := os.Open(configMapPath + "/" + sharingConfigMapKey)
+	if os.IsNotExist(err) {
+		return defaultStrategy, nil
+	}
+	if err != nil {
+		return defaultStrategy, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if scanner.Scan() {
+		if strategy := strings.TrimSpace(scanner.Text()); strategy != "" {
+			return strategy, nil
+		}
+	}
+	return defaultStrategy, scanner.Err()
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestInvertContainerDevices(t *testing.T) {
+	c1 := ContainerID{namespace: "ns", pod: "p1", container: "c1"}
+	c2 := ContainerID{namespace: "ns", pod: "p2", container: "c2"}
+
+	containerDevices := map[ContainerID][]string{
+		c1: {"GPU-1", "GPU-2"},
+		c2: {"GPU-1"},
+	}
+
+	got := invertContainerDevices(containerDevices)
+
+	want := map[string][]ContainerID{
+		"GPU-1": {c1, c2},
+		"GPU-2": {c1},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("invertContainerDevices() returned %d devices, want %d", len(got), len(want))
+	}
+	for device, wantContainers := range want {
+		gotContainers := got[device]
+		sort.Slice(gotContainers, func(i, j int) bool { return gotContainers[i].container < gotContainers[j].container })
+		sort.Slice(wantContainers, func(i, j int) bool { return wantContainers[i].container < wantContainers[j].container })
+		if !reflect.DeepEqual(gotContainers, wantContainers) {
+			t.Errorf("invertContainerDevices()[%q] = %v, want %v", device, gotContainers, wantContainers)
+		}
+	}
+}
+
+func TestShareOf(t *testing.T) {
+	c1 := ContainerID{namespace: "ns", pod: "p1", container: "c1"}
+	c2 := ContainerID{namespace: "ns", pod: "p2", container: "c2"}
+
+	tests := []struct {
+		name    string
+		sharers []ContainerID
+		want    float64
+	}{
+		{name: "no sharers", sharers: nil, want: 0},
+		{name: "one container", sharers: []ContainerID{c1}, want: 1},
+		{name: "two containers", sharers: []ContainerID{c1, c2}, want: 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shareOf(tt.sharers); got != tt.want {
+				t.Errorf("shareOf(%v) = %v, want %v", tt.sharers, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/NVIDIA/gpu-monitoring-tools/bindings/go/nvml"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// GPUCollectionDurationSeconds tracks how long one full scrape across
+	// all containers/devices takes, so operators can tune
+	// --collection-parallelism.
+	GPUCollectionDurationSeconds = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "gpu_collection_duration_seconds",
+			Help:    "Time taken to gather GPU metrics for all containers in one scrape",
+			Buckets: prometheus.DefBuckets,
+		})
+
+	// GPUCollectionErrorsTotal counts gather failures by device and stage,
+	// replacing plain glog.Errorf sinks so failures are visible in
+	// Prometheus rather than only in logs.
+	GPUCollectionErrorsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gpu_collection_errors_total",
+			Help: "Total GPU metric collection errors by device and stage",
+		},
+		[]string{"device", "stage"})
+)
+
+// gatherJob is one unit of worker-pool work: fetch NVML state for a single
+// container/device pair.
+type gatherJob struct {
+	container ContainerID
+	device    string
+}
+
+// gatherResult holds everything updateMetrics needs to set the Prometheus
+// vectors for one job, so the NVML calls that produced it can run
+// concurrently across many devices while only the aggregator goroutine
+// touches the metric vectors.
+type gatherResult struct {
+	job gatherJob
+
+	dw  deviceWrapper
+	err error
+
+	status    *nvml.DeviceStatus
+	statusErr error
+
+	dutyCycle    uint
+	dutyCycleErr error
+}
+
+// collectDeviceResults fans the gather work for containerDevices out across
+// a bounded pool of parallelism workers and returns one gatherResult per
+// container/device pair. Workers perform the blocking NVML calls and leave
+// the gauges set by updateMetrics alone, but they do write to two vectors:
+// gatherOne increments GPUCollectionErrorsTotal, and gatherMigDevice sets
+// MigMode for the parent GPU of each MIG device it resolves.
+func (m *MetricServer) collectDeviceResults(containerDevices map[ContainerID][]string) []gatherResult {
+	parallelism := m.collectionParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	jobs := make(chan gatherJob)
+	resultsCh := make(chan gatherResult)
+
+	var workers sync.WaitGroup
+	workers.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				resultsCh <- gatherOne(job)
+			}
+		}()
+	}
+
+	go func() {
+		for container, devices := range containerDevices {
+			for _, device := range devices {
+				jobs <- gatherJob{container: container, device: device}
+			}
+		}
+		close(jobs)
+	}()
+
+	go func() {
+		workers.Wait()
+		close(resultsCh)
+	}()
+
+	var results []gatherResult
+	for r := range resultsCh {
+		results = append(results, r)
+	}
+	return results
+}
+
+// gatherOne performs the blocking per-device NVML calls for job: resolving
+// the device handle, its status, and its duty cycle. It records failures
+// against GPUCollectionErrorsTotal itself, since Inc() on a counter is safe
+// to call concurrently (unlike Set() on a gauge, where two workers racing
+// on the same label combination could interleave).
+func gatherOne(job gatherJob) gatherResult {
+	result := gatherResult{job: job}
+
+	dw, err := g.gatherDevice(job.device)
+	if err != nil {
+		GPUCollectionErrorsTotal.WithLabelValues(job.device, "gather_device").Inc()
+		result.err = err
+		return result
+	}
+	result.dw = dw
+
+	status, err := g.gatherStatus(dw)
+	if err != nil {
+		GPUCollectionErrorsTotal.WithLabelValues(job.device, "gather_status").Inc()
+		result.statusErr = err
+	}
+	result.status = status
+
+	dutyCycle, err := g.gatherDutyCycle(dw.giveDevice().UUID, time.Second*10)
+	if err != nil && err != errMigDutyCycleUnsupported {
+		GPUCollectionErrorsTotal.WithLabelValues(job.device, "gather_duty_cycle").Inc()
+	}
+	result.dutyCycle = dutyCycle
+	result.dutyCycleErr = err
+
+	return result
+}